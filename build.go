@@ -12,7 +12,7 @@ func Build(pkg *Package) Target {
 	t := buildPackage(pkg)
 	if err := t.Result(); err == nil {
 		if pkg.isMain() {
-			t = Ld(pkg, t.(PkgTarget))
+			t = Ld(pkg, t.(PkgTarget), transitiveDeps(pkg)...)
 		}
 	}
 	return t
@@ -25,7 +25,7 @@ func buildPackage(pkg *Package) Target {
 		return errTarget{fmt.Errorf("buildPackage: %v", err)}
 	}
 	return pkg.ctx.targetOrMissing(fmt.Sprintf("compile:%s:%s", pkg.Scope, pkg.p.ImportPath), func() Target {
-		deps := buildDependencies(pkg.ctx, pkg.p.Imports...)
+		deps := buildDependencies(pkg.ctx, pkg.p.Dir, pkg.p.Imports...)
 		return Compile(pkg, deps...)
 	})
 }
@@ -38,40 +38,96 @@ func buildCommand(pkg *Package) Target {
 		if _, ok := stdlib[dep]; ok {
 			continue
 		}
-		pkg := resolvePackage(pkg.ctx, dep)
-		deps = append(deps, buildPackage(pkg))
+		dep := resolveImportPath(pkg.ctx, pkg.p.Dir, dep)
+		deps = append(deps, buildPackage(dep))
 	}
 	compile := Compile(pkg, deps...)
-	ld := Ld(pkg, compile)
+	ld := Ld(pkg, compile, transitiveDeps(pkg)...)
 	return ld
 }
 
+// transitiveDeps returns the PkgTarget for every non-stdlib package
+// pkg transitively imports. Linkers such as gccgo need the whole
+// import graph on their command line: unlike a gc archive, a gccgo
+// archive holds only its own package's code, so every dependency of a
+// dependency must still be named explicitly at link time.
+func transitiveDeps(pkg *Package) []PkgTarget {
+	seen := make(map[string]bool)
+	var pkgs []PkgTarget
+	var visit func(p *Package)
+	visit = func(p *Package) {
+		for _, imp := range p.p.Imports {
+			if _, ok := stdlib[imp]; ok {
+				continue
+			}
+			dep := resolveImportPath(p.ctx, p.p.Dir, imp)
+			if seen[dep.p.ImportPath] {
+				continue
+			}
+			seen[dep.p.ImportPath] = true
+			pkgs = append(pkgs, buildPackage(dep).(PkgTarget))
+			visit(dep)
+		}
+	}
+	visit(pkg)
+	return pkgs
+}
+
 // Compile returns a Target representing all the steps required to build a go package.
 func Compile(pkg *Package, deps ...Target) PkgTarget {
 	if err := pkg.Result(); err != nil {
 		return errTarget{fmt.Errorf("compile: %v", err)}
 	}
 	return pkg.ctx.addTargetIfMissing(fmt.Sprintf("compile:%s:%s", pkg.Scope, pkg.p.ImportPath), func() Target {
+		archive := filepath.Join(objdir(pkg), pkg.Name()+".a")
+		id, iderr := pkg.ctx.cache.actionID(pkg, inputFiles(pkg), deps...)
+		// withActionID tags a cache hit with the action ID it was
+		// matched against, so that a package which depends on pkg gets
+		// the same actionID contribution whether pkg was freshly built
+		// or satisfied from cache in this run.
+		withActionID := func(t Target) Target {
+			if iderr != nil {
+				return t
+			}
+			return &cachedActionTarget{PkgTarget: t.(PkgTarget), id: id}
+		}
+		if iderr == nil {
+			if ok, _ := pkg.ctx.cache.lookup(id, archive); ok {
+				return withActionID(cachedPackage(pkg))
+			}
+		}
 		if !isStale(pkg) {
-			return cachedPackage(pkg)
+			return withActionID(cachedPackage(pkg))
 		}
 		var gofiles []string
 		gofiles = append(gofiles, pkg.p.GoFiles...)
-		var objs []ObjTarget
+		gcdeps := deps
+		var cgoobjs []ObjTarget
 		if len(pkg.p.CgoFiles) > 0 {
-			// cgo, cgofiles := cgo(pkg, deps...)
-			// deps = append(deps, cgo[0])
-			// objs = append(objs, cgo...)
-			// gofiles = append(gofiles, cgofiles...)
+			var cgoTarget Target
+			var cgofiles []string
+			cgoTarget, cgoobjs, cgofiles = cgo(pkg, deps...)
+			gofiles = append(gofiles, cgofiles...)
+			// Gc compiles the cgo-generated sources, so it must not
+			// run until the cgo step that writes them has finished.
+			gcdeps = append(append([]Target{}, deps...), cgoTarget)
 		}
-		objs = append(objs, Gc(pkg, gofiles, deps...))
+		var objs []ObjTarget
+		objs = append(objs, Gc(pkg, gofiles, gcdeps...))
+		objs = append(objs, cgoobjs...)
 		for _, sfile := range pkg.p.SFiles {
 			objs = append(objs, Asm(pkg, sfile))
 		}
+		var installed PkgTarget
 		if pkg.Complete() {
-			return Install(pkg, objs[0].(PkgTarget))
+			installed = Install(pkg, objs[0].(PkgTarget)).(PkgTarget)
+		} else {
+			installed = Install(pkg, Pack(pkg, objs...)).(PkgTarget)
 		}
-		return Install(pkg, Pack(pkg, objs...))
+		if iderr == nil {
+			return &cacheTarget{PkgTarget: installed, cache: pkg.ctx.cache, id: id}
+		}
+		return installed
 	}).(PkgTarget)
 }
 
@@ -87,6 +143,7 @@ type gc struct {
 	target
 	pkg     *Package
 	gofiles []string
+	deps    []Target
 }
 
 func (g *gc) String() string {
@@ -101,7 +158,7 @@ func (g *gc) compile() error {
 		// TODO(dfc) gross
 		includes = append(includes, g.pkg.ExtraIncludes)
 	}
-	return g.pkg.ctx.tc.Gc(includes, importpath, g.pkg.p.Dir, g.Objfile(), g.gofiles, g.pkg.Complete())
+	return g.pkg.ctx.tc.Gc(includes, importpath, g.pkg.p.Dir, g.Objfile(), g.gofiles, g.pkg.Complete(), localImportPrefix(g.pkg), depPkgfiles(g.deps)...)
 }
 
 func (g *gc) Objfile() string {
@@ -120,11 +177,25 @@ func Gc(pkg *Package, gofiles []string, deps ...Target) interface {
 	gc := gc{
 		pkg:     pkg,
 		gofiles: gofiles,
+		deps:    deps,
 	}
-	gc.target = newTarget(gc.compile, deps...)
+	gc.target = newTarget(scheduled(pkg, depth(pkg), gc.compile), deps...)
 	return &gc
 }
 
+// depPkgfiles returns the archive file for every dep that produces a
+// package archive (a PkgTarget), skipping deps - such as a Cgo target -
+// that don't.
+func depPkgfiles(deps []Target) []string {
+	var files []string
+	for _, dep := range deps {
+		if pt, ok := dep.(PkgTarget); ok {
+			files = append(files, pt.Pkgfile())
+		}
+	}
+	return files
+}
+
 // PkgTarget represents a Target that produces a pkg (.a) file.
 type PkgTarget interface {
 	Target
@@ -134,29 +205,26 @@ type PkgTarget interface {
 }
 
 type pack struct {
-	c   chan error
-	pkg *Package
-}
-
-func (p *pack) Result() error {
-	err := <-p.c
-	p.c <- err
-	return err
+	target
+	pkg  *Package
+	objs []ObjTarget
 }
 
-func (p *pack) pack(objs ...ObjTarget) {
+// pack archives p.objs into the package's .a file. By the time this
+// runs, newTarget has already waited for every obj in p.objs to
+// complete (they were passed to newTarget as deps below), so reading
+// obj.Objfile() here never blocks on another target's Result() - doing
+// so from inside a scheduled closure would risk deadlocking the bounded
+// Scheduler if every worker were waiting on work that hadn't been
+// scheduled yet.
+func (p *pack) pack() error {
 	Debugf("pack %v", p.pkg)
-	afiles := make([]string, 0, len(objs))
-	for _, obj := range objs {
-		err := obj.Result()
-		if err != nil {
-			p.c <- err
-			return
-		}
+	afiles := make([]string, 0, len(p.objs))
+	for _, obj := range p.objs {
 		// pkg.a (compiled Go code) is always first
 		afiles = append(afiles, obj.Objfile())
 	}
-	p.c <- p.pkg.ctx.tc.Pack(afiles...)
+	return p.pkg.ctx.tc.Pack(afiles...)
 }
 
 func (p *pack) Pkgfile() string {
@@ -165,13 +233,14 @@ func (p *pack) Pkgfile() string {
 
 // Pack returns a Target representing the result of packing a
 // set of Context specific object files into an archive.
-func Pack(pkg *Package, deps ...ObjTarget) PkgTarget {
-	pack := pack{
-		c:   make(chan error, 1),
-		pkg: pkg,
+func Pack(pkg *Package, objs ...ObjTarget) PkgTarget {
+	pack := &pack{pkg: pkg, objs: objs}
+	deps := make([]Target, 0, len(objs))
+	for _, obj := range objs {
+		deps = append(deps, obj)
 	}
-	go pack.pack(deps...)
-	return &pack
+	pack.target = newTarget(scheduled(pkg, depth(pkg), pack.pack), deps...)
+	return pack
 }
 
 type asm struct {
@@ -196,7 +265,7 @@ func Asm(pkg *Package, sfile string) ObjTarget {
 		pkg:   pkg,
 		sfile: sfile,
 	}
-	asm.target = newTarget(asm.asm)
+	asm.target = newTarget(scheduled(pkg, depth(pkg), asm.asm))
 	return &asm
 }
 
@@ -204,28 +273,48 @@ type ld struct {
 	target
 	pkg   *Package
 	afile PkgTarget
+	deps  []PkgTarget
+}
+
+func (l *ld) afiles() []string {
+	afiles := make([]string, 0, len(l.deps)+1)
+	afiles = append(afiles, l.afile.Pkgfile())
+	for _, dep := range l.deps {
+		afiles = append(afiles, dep.Pkgfile())
+	}
+	return afiles
 }
 
 func (l *ld) link() error {
 	target := filepath.Join(objdir(l.pkg), l.pkg.p.Name)
-	Infof("link %v [%v]", target, l.afile.Pkgfile())
+	afiles := l.afiles()
+	Infof("link %v %v", target, afiles)
 	includes := l.pkg.ctx.IncludePaths()
 	if l.pkg.Scope == "test" {
 		// TODO(dfc) gross
 		includes = append(includes, l.pkg.ExtraIncludes)
 		target += ".test"
 	}
-	return l.pkg.ctx.tc.Ld(includes, target, l.afile.Pkgfile())
+	return l.pkg.ctx.tc.Ld(includes, target, afiles...)
 }
 
-// Ld returns a Target representing the result of linking a
-// Package into a command with the Context provided linker.
-func Ld(pkg *Package, afile PkgTarget) Target {
+// Ld returns a Target representing the result of linking a Package
+// into a command with the Context provided linker. deps are the
+// PkgTargets for every package afile transitively imports; toolchains
+// whose archives hold only their own package's code (gccgo) need all
+// of them on the link line.
+func Ld(pkg *Package, afile PkgTarget, deps ...PkgTarget) Target {
 	ld := ld{
 		pkg:   pkg,
 		afile: afile,
+		deps:  deps,
+	}
+	waitFor := make([]Target, 0, len(deps)+1)
+	waitFor = append(waitFor, afile)
+	for _, dep := range deps {
+		waitFor = append(waitFor, dep)
 	}
-	ld.target = newTarget(ld.link, afile)
+	ld.target = newTarget(scheduled(pkg, depth(pkg), ld.link), waitFor...)
 	return &ld
 }
 
@@ -236,27 +325,41 @@ func stripext(path string) string {
 
 // objdir returns the destination for object files compiled for this Package.
 func objdir(pkg *Package) string {
+	importpath := filepath.FromSlash(safeImportPath(pkg.p.ImportPath))
 	switch pkg.Scope {
 	case "test":
-		return filepath.Join(testobjdir(pkg), filepath.Dir(filepath.FromSlash(pkg.p.ImportPath)))
+		return filepath.Join(testobjdir(pkg), filepath.Dir(importpath))
 	default:
-		return filepath.Join(pkg.ctx.workdir, filepath.Dir(filepath.FromSlash(pkg.p.ImportPath)))
+		return filepath.Join(pkg.ctx.workdir, filepath.Dir(importpath))
 	}
 }
 
 func testobjdir(pkg *Package) string {
-	return filepath.Join(pkg.ctx.workdir, filepath.FromSlash(pkg.p.ImportPath), "_test")
+	return filepath.Join(pkg.ctx.workdir, filepath.FromSlash(safeImportPath(pkg.p.ImportPath)), "_test")
 }
 
-// buildDependencies resolves the dependencies the package paths.
-func buildDependencies(ctx *Context, imports ...string) []Target {
+// buildDependencies resolves the dependencies of the package rooted at
+// srcDir, the importing package's directory, which is needed to
+// resolve "./foo"-style relative imports.
+func buildDependencies(ctx *Context, srcDir string, imports ...string) []Target {
 	var deps []Target
 	for _, dep := range imports {
 		if _, ok := stdlib[dep]; ok {
 			continue
 		}
-		pkg := resolvePackage(ctx, dep)
+		pkg := resolveImportPath(ctx, srcDir, dep)
 		deps = append(deps, buildPackage(pkg))
 	}
 	return deps
 }
+
+// resolveImportPath resolves importPath to a Package, rewriting
+// relative imports ("./foo", "../foo") found in srcDir into the
+// synthetic "_/" path cmd/go uses for packages outside $GOPATH/src
+// before handing off to resolvePackage.
+func resolveImportPath(ctx *Context, srcDir, importPath string) *Package {
+	if isLocalImport(importPath) {
+		importPath = resolveLocalImport(srcDir, importPath)
+	}
+	return resolvePackage(ctx, importPath)
+}
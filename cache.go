@@ -0,0 +1,203 @@
+package gb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// actionCache is a content-addressed store of built package archives,
+// keyed by an action ID derived from everything that can affect a
+// build's output. Unlike isStale's mtime comparison, an actionCache
+// entry remains valid across workdir wipes and machine restarts as
+// long as none of its inputs changed.
+type actionCache struct {
+	dir string
+}
+
+// newActionCache returns the actionCache rooted at $GB_CACHE, defaulting
+// to $XDG_CACHE_HOME/gb (or $HOME/.cache/gb if XDG_CACHE_HOME is unset).
+func newActionCache() *actionCache {
+	dir := os.Getenv("GB_CACHE")
+	if dir == "" {
+		xdg := os.Getenv("XDG_CACHE_HOME")
+		if xdg == "" {
+			xdg = filepath.Join(os.Getenv("HOME"), ".cache")
+		}
+		dir = filepath.Join(xdg, "gb")
+	}
+	return &actionCache{dir: dir}
+}
+
+// versionedToolchain is implemented by Toolchains that can report a
+// version string. It is checked with a type assertion, rather than
+// added to the Toolchain interface outright, so that toolchains which
+// predate the action cache keep compiling unchanged.
+type versionedToolchain interface {
+	version() string
+}
+
+// toolchainVersion returns pkg's toolchain version for the action ID,
+// or "" if the toolchain doesn't report one.
+func toolchainVersion(pkg *Package) string {
+	if v, ok := pkg.ctx.tc.(versionedToolchain); ok {
+		return v.version()
+	}
+	return ""
+}
+
+// actionID computes the action ID for compiling pkg: the SHA-256 of
+// the toolchain version, the target GOOS/GOARCH, the import path,
+// whether the package is "complete" (no C dependencies to link in),
+// the compile flags that affect its output (cgo CFLAGS/LDFLAGS and
+// pkg-config directives), the sorted input files and their content
+// hashes, and the action IDs of pkg's dependencies. Any change to an
+// input anywhere in the dependency graph changes the action ID, and
+// therefore the cache key, of everything downstream.
+func (c *actionCache) actionID(pkg *Package, files []string, deps ...Target) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "toolchain %s\n", toolchainVersion(pkg))
+	fmt.Fprintf(h, "goos/goarch %s/%s\n", pkg.ctx.GOOS, pkg.ctx.GOARCH)
+	fmt.Fprintf(h, "importpath %s\n", pkg.p.ImportPath)
+	fmt.Fprintf(h, "complete %v\n", pkg.Complete())
+	fmt.Fprintf(h, "cgo-cflags %q\n", pkg.p.CgoCFLAGS)
+	fmt.Fprintf(h, "cgo-ldflags %q\n", pkg.p.CgoLDFLAGS)
+	fmt.Fprintf(h, "cgo-pkg-config %q\n", pkg.p.CgoPkgConfig)
+
+	sorted := append([]string(nil), files...)
+	sort.Strings(sorted)
+	for _, f := range sorted {
+		sum, err := hashFile(filepath.Join(pkg.p.Dir, f))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "file %s %x\n", f, sum)
+	}
+	for _, dep := range deps {
+		id, ok := dep.(interface {
+			actionID() string
+		})
+		if ok {
+			fmt.Fprintf(h, "dep %s\n", id.actionID())
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lookup returns the cached archive for id, if any, copying it to
+// dest. It reports whether the cache was hit.
+func (c *actionCache) lookup(id, dest string) (bool, error) {
+	src := c.path(id)
+	if _, err := os.Stat(src); err != nil {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return false, err
+	}
+	os.Remove(dest)
+	if err := os.Link(src, dest); err == nil {
+		return true, nil
+	}
+	return true, copyFile(dest, src)
+}
+
+// put stores the archive at file under id for future lookups.
+func (c *actionCache) put(id, file string) error {
+	dst := c.path(id)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	os.Remove(dst)
+	if err := os.Link(file, dst); err == nil {
+		return nil
+	}
+	return copyFile(dst, file)
+}
+
+// path returns the on-disk location for the archive cached under id:
+// $GB_CACHE/<aa>/<id>-a, sharded by the first two hex digits of id to
+// keep any one directory from growing too large.
+func (c *actionCache) path(id string) string {
+	return filepath.Join(c.dir, id[:2], id+"-a")
+}
+
+// clean removes every entry from the cache, implementing "gb clean -cache".
+func (c *actionCache) clean() error {
+	return os.RemoveAll(c.dir)
+}
+
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func copyFile(dst, src string) error {
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, b, 0644)
+}
+
+// inputFiles returns every source file that feeds into compiling pkg,
+// relative to pkg.p.Dir, in the combination used to compute an
+// actionID.
+func inputFiles(pkg *Package) []string {
+	var files []string
+	files = append(files, pkg.p.GoFiles...)
+	files = append(files, pkg.p.CgoFiles...)
+	files = append(files, pkg.p.CFiles...)
+	files = append(files, pkg.p.SFiles...)
+	return files
+}
+
+// cacheTarget wraps a PkgTarget so that once it successfully produces
+// an archive, the archive is stored in the cache under id for reuse by
+// a future build with the same action ID.
+type cacheTarget struct {
+	PkgTarget
+	cache *actionCache
+	id    string
+}
+
+func (c *cacheTarget) Result() error {
+	err := c.PkgTarget.Result()
+	if err == nil {
+		err = c.cache.put(c.id, c.PkgTarget.Pkgfile())
+	}
+	return err
+}
+
+// actionID returns the action ID this target was cached under, so
+// that dependants can fold it into their own action ID.
+func (c *cacheTarget) actionID() string {
+	return c.id
+}
+
+// cachedActionTarget wraps the PkgTarget returned for a cache hit (via
+// actionCache.lookup or isStale's mtime-based early-out) with the
+// action ID it was matched against. Without this, a dependant's
+// actionID silently drops any dependency satisfied from cache in the
+// current run, so the same source tree could hash differently
+// depending on which of its dependencies happened to be rebuilt.
+type cachedActionTarget struct {
+	PkgTarget
+	id string
+}
+
+func (c *cachedActionTarget) actionID() string {
+	return c.id
+}
@@ -0,0 +1,237 @@
+package gb
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// cgo returns the Target that must complete before gofiles can be
+// compiled, the Go sources Gc should compile in place of pkg.p.CgoFiles
+// (including the cgo-generated _cgo_import.go, which is Go source, not
+// an archive member, and so must be compiled rather than packed), and
+// the ObjTargets for pkg's C sources - both cgo-generated and plain -
+// that Pack should archive alongside the result of compiling gofiles.
+//
+// The returned Target is not cgo itself: the generated Go code calls
+// into the C halves of the cgo files and _cgo_export.c, so those must
+// be compiled to real, archived objects rather than discarded after a
+// throwaway dynimport probe link, and the returned Target waits for
+// that compilation too.
+func cgo(pkg *Package, deps ...Target) (cgoTarget Target, objs []ObjTarget, gofiles []string) {
+	cgo := Cgo(pkg, deps...)
+	gofiles = cgo.gofiles()
+
+	for _, cfile := range cgo.cfiles() {
+		objs = append(objs, CC(pkg, cfile, cgo))
+	}
+	for _, cfile := range pkg.p.CFiles {
+		objs = append(objs, CC(pkg, filepath.Join(pkg.p.Dir, cfile)))
+	}
+	mainobj := CC(pkg, cgo.mainfile(), cgo)
+
+	deps = make([]Target, 0, len(objs)+1)
+	for _, obj := range objs {
+		deps = append(deps, obj)
+	}
+	deps = append(deps, mainobj)
+	cgoTarget = Dynimport(pkg, cgo, objs, mainobj, deps...)
+
+	return cgoTarget, objs, gofiles
+}
+
+type cgoTarget struct {
+	target
+	pkg *Package
+
+	// workdir is the per-package cgo work directory that holds the
+	// generated _cgo_gotypes.go, *.cgo1.go/cgo2.c, _cgo_export.{c,h}
+	// and _cgo_main.c files.
+	workdir string
+}
+
+func (c *cgoTarget) String() string {
+	return fmt.Sprintf("cgo %v", c.pkg)
+}
+
+// gofiles returns the Go sources generated by the cgo tool that Gc
+// must compile: the per-file *.cgo1.go outputs, the shared
+// _cgo_gotypes.go, and _cgo_import.go, the dynamic import metadata
+// produced by "cgo -dynimport". All three are ordinary Go source and
+// belong in Gc's input list, not among the ObjTargets that Pack
+// archives.
+func (c *cgoTarget) gofiles() []string {
+	files := make([]string, 0, len(c.pkg.p.CgoFiles)+2)
+	files = append(files, filepath.Join(c.workdir, "_cgo_gotypes.go"))
+	for _, f := range c.pkg.p.CgoFiles {
+		files = append(files, filepath.Join(c.workdir, strings.TrimSuffix(f, ".go")+".cgo1.go"))
+	}
+	files = append(files, c.dynimportfile())
+	return files
+}
+
+// dynimportfile is the Go source cgo -dynimport produces, recording
+// the package's dynamic library dependencies.
+func (c *cgoTarget) dynimportfile() string {
+	return filepath.Join(c.workdir, "_cgo_import.go")
+}
+
+// cfiles returns the C halves generated alongside gofiles, plus
+// _cgo_export.c, which the generated Go code calls into and so must be
+// compiled and archived like any other ObjTarget. _cgo_main.c is not
+// among them: it only exists to give the dynimport probe link a main
+// function and is never archived; see mainfile.
+func (c *cgoTarget) cfiles() []string {
+	files := make([]string, 0, len(c.pkg.p.CgoFiles)+1)
+	for _, f := range c.pkg.p.CgoFiles {
+		files = append(files, filepath.Join(c.workdir, strings.TrimSuffix(f, ".go")+".cgo2.c"))
+	}
+	files = append(files, filepath.Join(c.workdir, "_cgo_export.c"))
+	return files
+}
+
+// mainfile returns the path of the cgo-generated _cgo_main.c, a stub
+// main used only to give the dynimport probe link in Dynimport
+// something to link; it is compiled like any other C file but never
+// archived.
+func (c *cgoTarget) mainfile() string {
+	return filepath.Join(c.workdir, "_cgo_main.c")
+}
+
+func (c *cgoTarget) run() error {
+	Infof("cgo %v", c.pkg.p.ImportPath)
+	pkg := c.pkg
+	cgoflags, _, _ := cgoFlags(pkg)
+
+	args := append([]string{"-objdir", c.workdir}, cgoflags...)
+	args = append(args, pkg.p.CgoFiles...)
+	return run(pkg.p.Dir, "cgo", args...)
+}
+
+// Cgo returns a Target representing the result of running the cgo tool
+// over pkg's CgoFiles, producing Go sources that can be compiled by the
+// normal Gc step, and the *.cgo2.c/_cgo_export.c/_cgo_main.c sources
+// that CC compiles in turn. It is a dependency of those CC targets, not
+// an ObjTarget itself: its own output is never compiled or archived
+// directly.
+func Cgo(pkg *Package, deps ...Target) interface {
+	Target
+	gofiles() []string
+	cfiles() []string
+	mainfile() string
+	dynimportfile() string
+} {
+	cgo := &cgoTarget{
+		pkg:     pkg,
+		workdir: filepath.Join(objdir(pkg), "_cgo", pkg.Name()),
+	}
+	cgo.target = newTarget(scheduled(pkg, depth(pkg), cgo.run), deps...)
+	return cgo
+}
+
+// dynimport is the Target that probe-links the compiled objects for
+// pkg's cgo and plain C files together with mainobj's stub main, then
+// runs "cgo -dynimport" over the result to produce _cgo_import.go,
+// recording the dynamic libraries the package depends on. It reuses
+// objs and mainobj's already-compiled Objfiles rather than compiling
+// the C sources a second time.
+type dynimport struct {
+	target
+	pkg *Package
+	cgo interface {
+		cfiles() []string
+		mainfile() string
+		dynimportfile() string
+	}
+	objs    []ObjTarget
+	mainobj ObjTarget
+}
+
+func (d *dynimport) String() string {
+	return fmt.Sprintf("dynimport %v", d.pkg)
+}
+
+func (d *dynimport) run() error {
+	pkg := d.pkg
+	_, _, ldflags := cgoFlags(pkg)
+
+	ofiles := make([]string, 0, len(d.objs)+1)
+	for _, obj := range d.objs {
+		ofiles = append(ofiles, obj.Objfile())
+	}
+	ofiles = append(ofiles, d.mainobj.Objfile())
+
+	dynout := filepath.Join(objdir(pkg), "_cgo_.o")
+	if err := pkg.ctx.tc.Ccld(pkg.p.Dir, dynout, ldflags, ofiles); err != nil {
+		return fmt.Errorf("cgo: link %v: %v", dynout, err)
+	}
+	return run(pkg.p.Dir, "cgo", "-dynimport", dynout, "-dynout", d.cgo.dynimportfile())
+}
+
+// Dynimport returns the Target described by dynimport, depending on
+// deps (objs and mainobj) so that by the time it runs, every object it
+// links has already been compiled.
+func Dynimport(pkg *Package, cgo interface {
+	cfiles() []string
+	mainfile() string
+	dynimportfile() string
+}, objs []ObjTarget, mainobj ObjTarget, deps ...Target) Target {
+	d := &dynimport{pkg: pkg, cgo: cgo, objs: objs, mainobj: mainobj}
+	d.target = newTarget(scheduled(pkg, depth(pkg), d.run), deps...)
+	return d
+}
+
+type cc struct {
+	target
+	pkg   *Package
+	cfile string
+}
+
+func (c *cc) String() string {
+	return fmt.Sprintf("cc %v", c.cfile)
+}
+
+func (c *cc) Objfile() string {
+	base := filepath.Base(c.cfile)
+	return filepath.Join(objdir(c.pkg), strings.TrimSuffix(base, filepath.Ext(base))+".o")
+}
+
+func (c *cc) compile() error {
+	Infof("cc %v", c.cfile)
+	_, cflags, _ := cgoFlags(c.pkg)
+	_, err := c.pkg.ctx.tc.Cc(c.pkg.p.Dir, objdir(c.pkg), cflags, c.cfile)
+	return err
+}
+
+// CC returns a Target representing the result of compiling a C source
+// file - one of pkg.p.CFiles, or a cgo-generated *.cgo2.c, _cgo_export.c
+// or _cgo_main.c under cfile - with the host C compiler, honouring any
+// "#cgo CFLAGS" directives found in pkg's cgo preamble. deps lets a cgo-
+// generated cfile depend on the Cgo Target that produces it.
+func CC(pkg *Package, cfile string, deps ...Target) ObjTarget {
+	cc := &cc{
+		pkg:   pkg,
+		cfile: cfile,
+	}
+	cc.target = newTarget(scheduled(pkg, depth(pkg), cc.compile), deps...)
+	return cc
+}
+
+// cgoFlags parses the "#cgo CFLAGS:", "#cgo LDFLAGS:" and "#cgo pkg-config:"
+// directives out of pkg's cgo preamble and returns the flags to pass to cgo
+// itself, to the C compiler, and to the linker respectively.
+func cgoFlags(pkg *Package) (cgoflags, cflags, ldflags []string) {
+	cflags = append(cflags, pkg.p.CgoCFLAGS...)
+	ldflags = append(ldflags, pkg.p.CgoLDFLAGS...)
+	if len(pkg.p.CgoPkgConfig) > 0 {
+		pkgconfig, err := run2(pkg.p.Dir, "pkg-config", append([]string{"--cflags"}, pkg.p.CgoPkgConfig...)...)
+		if err == nil {
+			cflags = append(cflags, strings.Fields(pkgconfig)...)
+		}
+		pkgconfig, err = run2(pkg.p.Dir, "pkg-config", append([]string{"--libs"}, pkg.p.CgoPkgConfig...)...)
+		if err == nil {
+			ldflags = append(ldflags, strings.Fields(pkgconfig)...)
+		}
+	}
+	return cgoflags, cflags, ldflags
+}
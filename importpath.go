@@ -0,0 +1,47 @@
+package gb
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// isLocalImport reports whether importPath is a relative import such
+// as "./foo" or "../foo", as opposed to a rooted import path.
+func isLocalImport(importPath string) bool {
+	return strings.HasPrefix(importPath, "./") || strings.HasPrefix(importPath, "../")
+}
+
+// resolveLocalImport rewrites a relative import, found in a file in
+// srcDir, into the absolute import path under the synthetic "_/" tree
+// that cmd/go uses for packages outside $GOPATH/src: "./foo" imported
+// from /home/gopher becomes "_/home/gopher/foo". Resolving local
+// imports this way lets the rest of gb - the package cache, objdir,
+// and archive lookups - treat them exactly like any other import path.
+func resolveLocalImport(srcDir, importPath string) string {
+	dir := filepath.Join(srcDir, importPath)
+	return "_" + filepath.ToSlash(filepath.Clean("/"+filepath.ToSlash(dir)))
+}
+
+// safeImportPath maps an import path onto a directory name that is
+// safe to use on all platforms. Only the synthetic "_/" tree needs
+// this: on Windows the absolute path it embeds contains a drive
+// letter followed by ':', which is not a legal path character.
+func safeImportPath(importPath string) string {
+	if strings.HasPrefix(importPath, "_/") {
+		return strings.Replace(importPath, ":", "", -1)
+	}
+	return importPath
+}
+
+// localImportPrefix returns the -D equivalent gb passes to the
+// compiler for a package registered under the synthetic "_/" tree: the
+// package's own "_/" import path, as cmd/go uses, so that a relative
+// import inside pkg resolves to the same "_/" path gb registered that
+// import under. Packages with an ordinary, non-rewritten import path
+// need no prefix.
+func localImportPrefix(pkg *Package) string {
+	if strings.HasPrefix(pkg.p.ImportPath, "_/") {
+		return pkg.p.ImportPath
+	}
+	return ""
+}
@@ -0,0 +1,52 @@
+package gb
+
+import "testing"
+
+func TestIsLocalImport(t *testing.T) {
+	tests := []struct {
+		importPath string
+		want       bool
+	}{
+		{"./foo", true},
+		{"../foo", true},
+		{"foo", false},
+		{"example.com/foo", false},
+	}
+	for _, tt := range tests {
+		if got := isLocalImport(tt.importPath); got != tt.want {
+			t.Errorf("isLocalImport(%q) = %v, want %v", tt.importPath, got, tt.want)
+		}
+	}
+}
+
+func TestResolveLocalImport(t *testing.T) {
+	tests := []struct {
+		srcDir     string
+		importPath string
+		want       string
+	}{
+		{"/home/gopher", "./foo", "_/home/gopher/foo"},
+		{"/home/gopher/bar", "../foo", "_/home/gopher/foo"},
+	}
+	for _, tt := range tests {
+		if got := resolveLocalImport(tt.srcDir, tt.importPath); got != tt.want {
+			t.Errorf("resolveLocalImport(%q, %q) = %q, want %q", tt.srcDir, tt.importPath, got, tt.want)
+		}
+	}
+}
+
+func TestSafeImportPath(t *testing.T) {
+	tests := []struct {
+		importPath string
+		want       string
+	}{
+		{"_/c:/gopher/foo", "_/c/gopher/foo"},
+		{"_/home/gopher/foo", "_/home/gopher/foo"},
+		{"example.com/foo", "example.com/foo"},
+	}
+	for _, tt := range tests {
+		if got := safeImportPath(tt.importPath); got != tt.want {
+			t.Errorf("safeImportPath(%q) = %q, want %q", tt.importPath, got, tt.want)
+		}
+	}
+}
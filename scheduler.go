@@ -0,0 +1,137 @@
+package gb
+
+import (
+	"container/heap"
+	"runtime"
+	"sync"
+)
+
+// Scheduler bounds the number of build actions - compiles, assembles,
+// packs and links - that run at once, so that a large build does not
+// fan out into hundreds of concurrent toolchain invocations. Work is
+// ordered by priority, with higher priority actions run first; callers
+// pass a priority that favours deep dependency chains so the critical
+// path of the build finishes soonest, mirroring the heap ordering in
+// cmd/go's build.go.
+type Scheduler struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   schedQueue
+	workers int
+	started bool
+}
+
+// NewScheduler returns a Scheduler that runs at most n actions
+// concurrently. If n is <= 0, runtime.NumCPU() is used. n is the value
+// of Context.Parallelism, which the gb command line sets from its -p
+// flag.
+func NewScheduler(n int) *Scheduler {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	s := &Scheduler{workers: n}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+var (
+	schedulersMu sync.Mutex
+	schedulers   = map[*Context]*Scheduler{}
+)
+
+// schedulerFor returns the Scheduler shared by every target built
+// against pkg's Context, sized by ctx.Parallelism (-p), creating it on
+// first use so all of a build's Gc/Asm/Cgo/CC/Ld/Pack actions are
+// bounded by the one pool rather than each spawning its own.
+func schedulerFor(pkg *Package) *Scheduler {
+	ctx := pkg.ctx
+	schedulersMu.Lock()
+	defer schedulersMu.Unlock()
+	s, ok := schedulers[ctx]
+	if !ok {
+		s = NewScheduler(ctx.Parallelism)
+		schedulers[ctx] = s
+	}
+	return s
+}
+
+// scheduled wraps fn so that running it first waits for a free slot on
+// pkg's Scheduler. Gc, Asm, Cgo, CC, Ld and Pack pass their real
+// compile/assemble/link/pack function through this before handing it
+// to newTarget, so the toolchain invocations they perform are bounded
+// by -p regardless of how many targets happen to be runnable at once.
+//
+// fn must not block on another Target's Result(): by the time a
+// scheduled fn runs, it already holds one of the pool's limited slots,
+// so waiting here on a dependency that itself needs a slot to start
+// can deadlock the whole pool. Dependencies belong in the deps passed
+// to newTarget, which waits for them before fn is ever invoked.
+func scheduled(pkg *Package, priority int, fn func() error) func() error {
+	return func() error {
+		return <-schedulerFor(pkg).Schedule(priority, fn)
+	}
+}
+
+// Schedule submits fn to be run once a worker is free, and returns a
+// channel on which fn's result is delivered exactly once. priority
+// determines queue order: higher values run first.
+func (s *Scheduler) Schedule(priority int, fn func() error) <-chan error {
+	w := &schedWork{priority: priority, fn: fn, result: make(chan error, 1)}
+	s.mu.Lock()
+	heap.Push(&s.queue, w)
+	if !s.started {
+		s.started = true
+		for i := 0; i < s.workers; i++ {
+			go s.work()
+		}
+	}
+	s.cond.Signal()
+	s.mu.Unlock()
+	return w.result
+}
+
+// work is run by each of the Scheduler's worker goroutines. It pulls
+// the highest priority item off the queue, blocking until one is
+// available, and runs it.
+func (s *Scheduler) work() {
+	for {
+		s.mu.Lock()
+		for s.queue.Len() == 0 {
+			s.cond.Wait()
+		}
+		w := heap.Pop(&s.queue).(*schedWork)
+		s.mu.Unlock()
+		w.result <- w.fn()
+	}
+}
+
+// schedWork is a single unit of work submitted to a Scheduler.
+type schedWork struct {
+	priority int
+	fn       func() error
+	result   chan error
+}
+
+// schedQueue is a container/heap.Interface of pending schedWork items,
+// ordered highest priority first.
+type schedQueue []*schedWork
+
+func (q schedQueue) Len() int            { return len(q) }
+func (q schedQueue) Less(i, j int) bool  { return q[i].priority > q[j].priority }
+func (q schedQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *schedQueue) Push(x interface{}) { *q = append(*q, x.(*schedWork)) }
+func (q *schedQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	*q = old[:n-1]
+	return w
+}
+
+// depth returns a rough priority for scheduling pkg's remaining build
+// steps: packages further from the leaves of the import graph - those
+// with more imports of their own - are scheduled ahead of shallow ones
+// so that the longest dependency chains start first.
+func depth(pkg *Package) int {
+	return len(pkg.p.Imports)
+}
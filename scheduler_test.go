@@ -0,0 +1,29 @@
+package gb
+
+import (
+	"container/heap"
+	"testing"
+)
+
+func TestSchedQueueOrder(t *testing.T) {
+	var q schedQueue
+	heap.Init(&q)
+	for _, p := range []int{1, 5, 3, 5, 0} {
+		heap.Push(&q, &schedWork{priority: p})
+	}
+
+	var got []int
+	for q.Len() > 0 {
+		got = append(got, heap.Pop(&q).(*schedWork).priority)
+	}
+
+	want := []int{5, 5, 3, 1, 0}
+	if len(got) != len(want) {
+		t.Fatalf("popped %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("pop %d = %d, want %d (full: %v)", i, got[i], want[i], got)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package gb
+
+import "testing"
+
+func TestLdArgs(t *testing.T) {
+	got := ldArgs(
+		[]string{"/usr/include"},
+		"/tmp/out/main",
+		[]string{"/tmp/pkg/main.a", "/tmp/pkg/foo.a"},
+	)
+	want := []string{
+		"-o", "/tmp/out/main",
+		"-L", "/usr/include",
+		"-L", "/tmp/pkg/", "-l:main.a",
+		"-L", "/tmp/pkg/", "-l:foo.a",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ldArgs = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ldArgs[%d] = %q, want %q (full: %v)", i, got[i], want[i], got)
+		}
+	}
+}